@@ -0,0 +1,57 @@
+package exporter
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAuthentication is returned by API methods when 3CX rejects the
+// configured credentials.
+var ErrAuthentication = errors.New("3cx: authentication failed")
+
+// API is the interface implemented by the 3CX client used by Exporter. It is
+// deliberately narrow so tests can supply a fake covering only what a given
+// scrape needs.
+type API interface {
+	SystemStatus() (*SystemStatus, error)
+	ServiceList() ([]Service, error)
+	TrunkList() ([]Trunk, error)
+
+	// CallHistory returns recently finished calls, most recent first. It
+	// feeds the call duration histogram and is used to correlate service
+	// resource samples, via exemplars, with the most recent finished call
+	// each service handled.
+	CallHistory() ([]CallRecord, error)
+
+	// QueueList returns the current call-center queues.
+	QueueList() ([]Queue, error)
+
+	// AgentList returns the current call-center agents, across all queues.
+	AgentList() ([]Agent, error)
+}
+
+// SystemStatus mirrors the 3CX system status endpoint.
+type SystemStatus struct {
+	BlacklistedIPCount   int
+	CallsActive          int
+	MaxSimCalls          int
+	ExtensionsTotal      int
+	ExtensionsRegistered int
+	LastBackupDateTime   *time.Time
+	MaintenanceExpiresAt *time.Time
+}
+
+// Service describes one 3CX backend service (e.g. the phone system or call
+// history service).
+type Service struct {
+	Name       string
+	Status     int
+	CPUUsage   float64
+	MemoryUsed float64
+}
+
+// Trunk describes one SIP trunk.
+type Trunk struct {
+	Name         string
+	IsRegistered bool
+}
@@ -0,0 +1,315 @@
+package exporter
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scrapeDurationDesc = prometheus.NewDesc(prefix+"scrape_duration_seconds", "Duration of the upstream API call backing an endpoint's collector", []string{"endpoint"}, nil)
+	scrapeSuccessDesc  = prometheus.NewDesc(prefix+"scrape_success", "Whether the upstream API call backing an endpoint's collector succeeded", []string{"endpoint"}, nil)
+)
+
+// endpointCache memoizes the result of a single upstream API call for ttl,
+// so a burst of concurrent Gather calls doesn't force a fresh round-trip
+// for every one of them.
+type endpointCache struct {
+	ttl   time.Duration
+	fetch func() (interface{}, error)
+
+	mu       sync.Mutex
+	fetched  time.Time
+	duration time.Duration
+	value    interface{}
+	err      error
+}
+
+func newEndpointCache(ttl time.Duration, fetch func() (interface{}, error)) *endpointCache {
+	return &endpointCache{ttl: ttl, fetch: fetch}
+}
+
+// get returns the cached value if it is younger than ttl, otherwise it
+// calls fetch, caches, and returns the fresh result.
+func (c *endpointCache) get() (interface{}, time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fetched.IsZero() && time.Since(c.fetched) < c.ttl {
+		return c.value, c.duration, c.err
+	}
+
+	start := time.Now()
+	value, err := c.fetch()
+	c.duration = time.Since(start)
+	c.value, c.err = value, err
+	c.fetched = time.Now()
+	return c.value, c.duration, c.err
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SystemCollector collects the system-status metrics on its own cached
+// upstream call, independently of ServiceCollector and TrunkCollector, so
+// a slow SystemStatus call doesn't hold up the rest of a scrape.
+type SystemCollector struct {
+	cache *endpointCache
+}
+
+// NewSystemCollector builds a SystemCollector that calls api.SystemStatus
+// at most once per ttl.
+func NewSystemCollector(api API, ttl time.Duration) *SystemCollector {
+	return &SystemCollector{cache: newEndpointCache(ttl, func() (interface{}, error) {
+		return api.SystemStatus()
+	})}
+}
+
+// Describe implements prometheus.Collector.
+func (c *SystemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- blacklistSizeDesc
+	ch <- callsActiveDesc
+	ch <- callsLimitDesc
+	ch <- extensionsTotalDesc
+	ch <- extensionsRegisteredDesc
+	ch <- backupAgeDesc
+	ch <- maintenanceRemainingDesc
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *SystemCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	value, duration, err := c.cache.get()
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), "system_status")
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, boolToFloat(err == nil), "system_status")
+	if err != nil {
+		if err == ErrAuthentication {
+			log.Println("authentication failed:", err)
+		} else {
+			log.Println("failed to fetch SystemStatus:", err)
+		}
+		return
+	}
+
+	status := value.(*SystemStatus)
+	ch <- prometheus.MustNewConstMetric(blacklistSizeDesc, prometheus.GaugeValue, float64(status.BlacklistedIPCount))
+	ch <- prometheus.MustNewConstMetric(callsActiveDesc, prometheus.GaugeValue, float64(status.CallsActive))
+	ch <- prometheus.MustNewConstMetric(callsLimitDesc, prometheus.GaugeValue, float64(status.MaxSimCalls))
+	ch <- prometheus.MustNewConstMetric(extensionsTotalDesc, prometheus.GaugeValue, float64(status.ExtensionsTotal))
+	ch <- prometheus.MustNewConstMetric(extensionsRegisteredDesc, prometheus.GaugeValue, float64(status.ExtensionsRegistered))
+
+	backupAgo := float64(-1)
+	if t := status.LastBackupDateTime; t != nil {
+		backupAgo = float64(now.Sub(*t)) / float64(time.Second)
+	}
+	ch <- prometheus.MustNewConstMetric(backupAgeDesc, prometheus.CounterValue, backupAgo)
+
+	maintenanceRemaining := float64(-1)
+	if t := status.MaintenanceExpiresAt; t != nil {
+		maintenanceRemaining = float64(t.Sub(now)) / float64(time.Second)
+	}
+	ch <- prometheus.MustNewConstMetric(maintenanceRemainingDesc, prometheus.CounterValue, maintenanceRemaining)
+}
+
+// ServiceCollector collects the per-service metrics on its own cached
+// upstream call.
+type ServiceCollector struct {
+	cache *endpointCache
+}
+
+// NewServiceCollector builds a ServiceCollector that calls api.ServiceList
+// at most once per ttl.
+func NewServiceCollector(api API, ttl time.Duration) *ServiceCollector {
+	return &ServiceCollector{cache: newEndpointCache(ttl, func() (interface{}, error) {
+		return api.ServiceList()
+	})}
+}
+
+// Describe implements prometheus.Collector.
+func (c *ServiceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serviceStatusDesc
+	ch <- serviceCPUDesc
+	ch <- serviceMemoryDesc
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *ServiceCollector) Collect(ch chan<- prometheus.Metric) {
+	value, duration, err := c.cache.get()
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), "services")
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, boolToFloat(err == nil), "services")
+	if err != nil {
+		log.Println("failed to fetch ServiceList:", err)
+		return
+	}
+
+	services := value.([]Service)
+	for i := range services {
+		service := services[i]
+		labels := []string{service.Name}
+
+		ch <- prometheus.MustNewConstMetric(serviceStatusDesc, prometheus.GaugeValue, float64(service.Status), labels...)
+		ch <- prometheus.MustNewConstMetric(serviceCPUDesc, prometheus.GaugeValue, float64(service.CPUUsage), labels...)
+		ch <- prometheus.MustNewConstMetric(serviceMemoryDesc, prometheus.GaugeValue, float64(service.MemoryUsed), labels...)
+	}
+}
+
+// TrunkCollector collects the per-trunk metrics on its own cached
+// upstream call.
+type TrunkCollector struct {
+	cache *endpointCache
+}
+
+// NewTrunkCollector builds a TrunkCollector that calls api.TrunkList at
+// most once per ttl.
+func NewTrunkCollector(api API, ttl time.Duration) *TrunkCollector {
+	return &TrunkCollector{cache: newEndpointCache(ttl, func() (interface{}, error) {
+		return api.TrunkList()
+	})}
+}
+
+// Describe implements prometheus.Collector.
+func (c *TrunkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- trunkRegisteredDesc
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *TrunkCollector) Collect(ch chan<- prometheus.Metric) {
+	value, duration, err := c.cache.get()
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), "trunks")
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, boolToFloat(err == nil), "trunks")
+	if err != nil {
+		log.Println("failed to fetch TrunkList:", err)
+		return
+	}
+
+	trunks := value.([]Trunk)
+	for i := range trunks {
+		trunk := trunks[i]
+		labels := []string{trunk.Name}
+
+		registered := 0
+		if trunk.IsRegistered {
+			registered = 1
+		}
+		ch <- prometheus.MustNewConstMetric(trunkRegisteredDesc, prometheus.GaugeValue, float64(registered), labels...)
+	}
+}
+
+// QueueCollector collects the per-queue metrics on its own cached upstream
+// call.
+type QueueCollector struct {
+	cache *endpointCache
+}
+
+// NewQueueCollector builds a QueueCollector that calls api.QueueList at
+// most once per ttl.
+func NewQueueCollector(api API, ttl time.Duration) *QueueCollector {
+	return &QueueCollector{cache: newEndpointCache(ttl, func() (interface{}, error) {
+		return api.QueueList()
+	})}
+}
+
+// Describe implements prometheus.Collector.
+func (c *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueCallsWaitingDesc
+	ch <- queueCallsAnsweredDesc
+	ch <- queueWaitTimeDesc
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *QueueCollector) Collect(ch chan<- prometheus.Metric) {
+	value, duration, err := c.cache.get()
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), "queues")
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, boolToFloat(err == nil), "queues")
+	if err != nil {
+		log.Println("failed to fetch QueueList:", err)
+		return
+	}
+
+	queues := value.([]Queue)
+	for i := range queues {
+		queue := queues[i]
+		labels := []string{queue.Name}
+
+		ch <- prometheus.MustNewConstMetric(queueCallsWaitingDesc, prometheus.GaugeValue, float64(queue.CallsWaiting), labels...)
+		ch <- prometheus.MustNewConstMetric(queueCallsAnsweredDesc, prometheus.CounterValue, float64(queue.CallsAnswered), labels...)
+		ch <- prometheus.MustNewConstHistogram(queueWaitTimeDesc, queue.WaitTime.Count, queue.WaitTime.Sum, queue.WaitTime.Buckets, labels...)
+	}
+}
+
+// AgentCollector collects the per-agent metrics on its own cached upstream
+// call.
+type AgentCollector struct {
+	cache *endpointCache
+}
+
+// NewAgentCollector builds an AgentCollector that calls api.AgentList at
+// most once per ttl.
+func NewAgentCollector(api API, ttl time.Duration) *AgentCollector {
+	return &AgentCollector{cache: newEndpointCache(ttl, func() (interface{}, error) {
+		return api.AgentList()
+	})}
+}
+
+// Describe implements prometheus.Collector.
+func (c *AgentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- agentStatusDesc
+	ch <- agentTalkTimeDesc
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *AgentCollector) Collect(ch chan<- prometheus.Metric) {
+	value, duration, err := c.cache.get()
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), "agents")
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, boolToFloat(err == nil), "agents")
+	if err != nil {
+		log.Println("failed to fetch AgentList:", err)
+		return
+	}
+
+	agents := value.([]Agent)
+	for i := range agents {
+		agent := agents[i]
+		labels := []string{agent.Queue, agent.Extension}
+
+		ch <- prometheus.MustNewConstMetric(agentStatusDesc, prometheus.GaugeValue, float64(agent.Status), labels...)
+		ch <- prometheus.MustNewConstMetric(agentTalkTimeDesc, prometheus.CounterValue, agent.TalkTime.Seconds(), labels...)
+	}
+}
+
+// NewTransactionalGatherer builds a registry holding one independent
+// collector per upstream endpoint (system status, services, trunks,
+// queues, agents), each caching its upstream call for ttl, and returns it
+// as a prometheus.TransactionalGatherer suitable for
+// promhttp.HandlerForTransactional. prometheus.Registry gathers its
+// collectors concurrently, so a slow upstream call no longer holds a
+// scrape hostage to the others, and HandlerForTransactional still serves
+// a single consistent snapshot per request.
+func NewTransactionalGatherer(api API, ttl time.Duration) prometheus.TransactionalGatherer {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		NewSystemCollector(api, ttl),
+		NewServiceCollector(api, ttl),
+		NewTrunkCollector(api, ttl),
+		NewQueueCollector(api, ttl),
+		NewAgentCollector(api, ttl),
+	)
+	return prometheus.ToTransactionalGatherer(reg)
+}
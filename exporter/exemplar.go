@@ -0,0 +1,55 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CallRecord describes one finished call pulled from 3CX call history,
+// used to attach exemplars to metrics that a call correlates with.
+type CallRecord struct {
+	CallID   string
+	Caller   string
+	Callee   string
+	Service  string // backend service that handled the call, if known
+	Duration time.Duration
+
+	// FinishedAt is when the call ended.
+	FinishedAt time.Time
+
+	// TraceParent is the optional W3C traceparent header captured for the
+	// call, if the 3CX deployment propagates one.
+	TraceParent string
+}
+
+// exemplarLabels builds the exemplar label set for call, omitting
+// traceparent when it wasn't captured.
+func (call CallRecord) exemplarLabels() prometheus.Labels {
+	labels := prometheus.Labels{
+		"call_id": call.CallID,
+		"caller":  call.Caller,
+		"callee":  call.Callee,
+	}
+	if call.TraceParent != "" {
+		labels["traceparent"] = call.TraceParent
+	}
+	return labels
+}
+
+// lastCallByService indexes calls, most recent first, by the service that
+// handled them, for use as a sampleOnce exemplar lookup.
+func lastCallByService(calls []CallRecord) func(name string) (CallRecord, bool) {
+	byService := make(map[string]CallRecord, len(calls))
+	for i := len(calls) - 1; i >= 0; i-- {
+		call := calls[i]
+		if call.Service == "" {
+			continue
+		}
+		byService[call.Service] = call
+	}
+	return func(name string) (CallRecord, bool) {
+		call, ok := byService[name]
+		return call, ok
+	}
+}
@@ -2,6 +2,7 @@ package exporter
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,11 +24,65 @@ var (
 	serviceMemoryDesc = prometheus.NewDesc(prefix+"service_memory", "Memory usage of service", []string{"name"}, nil)
 
 	trunkRegisteredDesc = prometheus.NewDesc(prefix+"trunk_registered", "Status of trunk", []string{"name"}, nil)
+
+	queueCallsWaitingDesc  = prometheus.NewDesc(prefix+"queue_calls_waiting", "Number of calls currently waiting in queue", []string{"queue"}, nil)
+	queueCallsAnsweredDesc = prometheus.NewDesc(prefix+"queue_calls_answered_total", "Total number of calls answered from queue", []string{"queue"}, nil)
+	queueWaitTimeDesc      = prometheus.NewDesc(prefix+"queue_wait_time_seconds", "Time answered calls spent waiting in queue", []string{"queue"}, nil)
+
+	agentStatusDesc   = prometheus.NewDesc(prefix+"agent_status", "Status of agent in queue", []string{"queue", "agent"}, nil)
+	agentTalkTimeDesc = prometheus.NewDesc(prefix+"agent_talk_time_seconds_total", "Cumulative talk time of agent in queue", []string{"queue", "agent"}, nil)
 )
 
 // Exporter represents a prometheus exporter
 type Exporter struct {
 	API
+
+	// histCfg and the fields below back the optional native-histogram
+	// collection path started by NewExporter. They are left zero-valued
+	// when Exporter is built via a bare struct literal, in which case
+	// Describe/Collect skip them.
+	histCfg HistogramConfig
+
+	callDurationHist    prometheus.Histogram
+	serviceCPUHist      *prometheus.HistogramVec
+	serviceMemoryHist   *prometheus.HistogramVec
+	trunkRegLatencyHist *prometheus.HistogramVec
+
+	trunkStateMu            sync.Mutex
+	trunkLastUnregisteredAt map[string]time.Time
+
+	lastObservedCallMu  sync.Mutex
+	lastObservedCallID  string
+	lastObservedCallSet bool
+
+	stopSampling      chan struct{}
+	closeSamplingOnce sync.Once
+
+	// systemCollector and the collectors below back Collect, one per
+	// upstream endpoint, each with its own cached fetch, so a slow
+	// endpoint doesn't block the others. They're built lazily on first
+	// Collect so Exporter keeps working when constructed via a bare
+	// struct literal instead of NewExporter.
+	systemCollector    *SystemCollector
+	serviceCollector   *ServiceCollector
+	trunkCollector     *TrunkCollector
+	queueCollector     *QueueCollector
+	agentCollector     *AgentCollector
+	initCollectorsOnce sync.Once
+}
+
+// ensureCollectors lazily builds the per-endpoint collectors backing
+// Collect, so Exporter works whether it was constructed via NewExporter
+// or a bare struct literal.
+func (ex *Exporter) ensureCollectors() {
+	ex.initCollectorsOnce.Do(func() {
+		ttl := ex.histCfg.ScrapeCacheTTL
+		ex.systemCollector = NewSystemCollector(ex.API, ttl)
+		ex.serviceCollector = NewServiceCollector(ex.API, ttl)
+		ex.trunkCollector = NewTrunkCollector(ex.API, ttl)
+		ex.queueCollector = NewQueueCollector(ex.API, ttl)
+		ex.agentCollector = NewAgentCollector(ex.API, ttl)
+	})
 }
 
 // Describe describes the metrics
@@ -45,68 +100,57 @@ func (ex *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- serviceMemoryDesc
 
 	ch <- trunkRegisteredDesc
-}
 
-// Collect collects the metrics
-func (ex *Exporter) Collect(ch chan<- prometheus.Metric) {
-	now := time.Now()
+	ch <- queueCallsWaitingDesc
+	ch <- queueCallsAnsweredDesc
+	ch <- queueWaitTimeDesc
 
-	status, err := ex.API.SystemStatus()
-	if err == ErrAuthentication {
-		log.Println("authentication failed:", err)
-		return
-	}
-	if err == nil {
-		ch <- prometheus.MustNewConstMetric(blacklistSizeDesc, prometheus.GaugeValue, float64(status.BlacklistedIPCount))
-		ch <- prometheus.MustNewConstMetric(callsActiveDesc, prometheus.GaugeValue, float64(status.CallsActive))
-		ch <- prometheus.MustNewConstMetric(callsLimitDesc, prometheus.GaugeValue, float64(status.MaxSimCalls))
-		ch <- prometheus.MustNewConstMetric(extensionsTotalDesc, prometheus.GaugeValue, float64(status.ExtensionsTotal))
-		ch <- prometheus.MustNewConstMetric(extensionsRegisteredDesc, prometheus.GaugeValue, float64(status.ExtensionsRegistered))
-
-		// seconds since last backup
-		backupAgo := float64(-1)
-		if t := status.LastBackupDateTime; t != nil {
-			backupAgo = float64(now.Sub(*t)) / float64(time.Second)
-		}
-		ch <- prometheus.MustNewConstMetric(backupAgeDesc, prometheus.CounterValue, backupAgo)
+	ch <- agentStatusDesc
+	ch <- agentTalkTimeDesc
 
-		// remaining time of maintenance
-		maintenanceRemaining := float64(-1)
-		if t := status.MaintenanceExpiresAt; t != nil {
-			maintenanceRemaining = float64(t.Sub(now)) / float64(time.Second)
-		}
-		ch <- prometheus.MustNewConstMetric(maintenanceRemainingDesc, prometheus.CounterValue, maintenanceRemaining)
-	} else {
-		log.Println("failed to fetch SystemStatus:", err)
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+
+	ex.describeHistograms(ch)
+}
+
+// Collect collects the metrics. Each upstream endpoint (system status,
+// services, trunks, queues, agents) is fetched concurrently through its
+// own cached collector, so a single slow 3CX API call no longer holds up
+// the rest of the scrape. Call history is fetched concurrently with them
+// but uncached, on every Collect.
+func (ex *Exporter) Collect(ch chan<- prometheus.Metric) {
+	ex.ensureCollectors()
+
+	endpointCollectors := []prometheus.Collector{
+		ex.systemCollector,
+		ex.serviceCollector,
+		ex.trunkCollector,
+		ex.queueCollector,
+		ex.agentCollector,
 	}
 
-	services, err := ex.API.ServiceList()
-	if err == nil {
-		for i := range services {
-			service := services[i]
-			labels := []string{service.Name}
+	var wg sync.WaitGroup
+	wg.Add(len(endpointCollectors) + 1)
 
-			ch <- prometheus.MustNewConstMetric(serviceStatusDesc, prometheus.GaugeValue, float64(service.Status), labels...)
-			ch <- prometheus.MustNewConstMetric(serviceCPUDesc, prometheus.GaugeValue, float64(service.CPUUsage), labels...)
-			ch <- prometheus.MustNewConstMetric(serviceMemoryDesc, prometheus.GaugeValue, float64(service.MemoryUsed), labels...)
-		}
-	} else {
-		log.Println("failed to fetch ServiceList:", err)
+	for _, c := range endpointCollectors {
+		c := c
+		go func() {
+			defer wg.Done()
+			c.Collect(ch)
+		}()
 	}
 
-	trunks, err := ex.API.TrunkList()
-	if err == nil {
-		for i := range trunks {
-			trunk := trunks[i]
-			labels := []string{trunk.Name}
-
-			registered := 0
-			if trunk.IsRegistered {
-				registered = 1
-			}
-			ch <- prometheus.MustNewConstMetric(trunkRegisteredDesc, prometheus.GaugeValue, float64(registered), labels...)
+	var calls []CallRecord
+	go func() {
+		defer wg.Done()
+		var err error
+		calls, err = ex.API.CallHistory()
+		if err != nil {
+			log.Println("failed to fetch CallHistory:", err)
 		}
-	} else {
-		log.Println("failed to fetch TrunkList:", err)
-	}
+	}()
+
+	wg.Wait()
+	ex.collectHistograms(ch, calls)
 }
@@ -0,0 +1,257 @@
+package exporter
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HistogramConfig configures the optional native-histogram collection path
+// added alongside the classic gauges in Exporter. Native histograms require
+// Prometheus 2.40+; the classic buckets configured here are always emitted
+// too, so older servers keep working.
+type HistogramConfig struct {
+	// SampleInterval controls how often service resource usage and trunk
+	// registration state are sampled in the background. Defaults to 15s
+	// when zero.
+	SampleInterval time.Duration
+
+	// NativeHistogramBucketFactor is the growth factor between sparse
+	// native histogram buckets. Zero disables native histograms and only
+	// the classic buckets below are exposed.
+	NativeHistogramBucketFactor float64
+
+	// NativeHistogramMaxBucketNumber bounds the number of sparse buckets
+	// kept per native histogram before old buckets are merged.
+	NativeHistogramMaxBucketNumber uint32
+
+	// CallDurationBuckets are the classic bucket boundaries, in seconds,
+	// for the call duration histogram.
+	CallDurationBuckets []float64
+
+	// ServiceResourceBuckets are the classic bucket boundaries used for
+	// the per-service CPU and memory histograms.
+	ServiceResourceBuckets []float64
+
+	// TrunkRegistrationBuckets are the classic bucket boundaries, in
+	// seconds, for the trunk registration latency histogram.
+	TrunkRegistrationBuckets []float64
+
+	// ScrapeCacheTTL bounds how often each upstream endpoint (system
+	// status, services, trunks, queues, agents) is actually fetched; a
+	// scrape within the TTL of the previous one reuses its result. Zero
+	// disables caching and fetches every endpoint on every scrape.
+	ScrapeCacheTTL time.Duration
+}
+
+// DefaultHistogramConfig returns native histograms enabled with the
+// client_golang defaults and a 15s background sampling interval.
+func DefaultHistogramConfig() HistogramConfig {
+	return HistogramConfig{
+		SampleInterval:                 15 * time.Second,
+		NativeHistogramBucketFactor:    1.1,
+		NativeHistogramMaxBucketNumber: 100,
+		CallDurationBuckets:            prometheus.DefBuckets,
+		ServiceResourceBuckets:         []float64{0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10},
+		TrunkRegistrationBuckets:       []float64{0.5, 1, 2.5, 5, 10, 30, 60},
+		ScrapeCacheTTL:                 10 * time.Second,
+	}
+}
+
+// NewExporter builds an Exporter backed by api. In addition to the classic
+// per-scrape gauges, it starts a background goroutine that samples service
+// resource usage and trunk registration state on cfg.SampleInterval and
+// records them as histograms, so per-sample distributions are available
+// even between scrapes. Call durations are observed at scrape time, from
+// the call history fetched by Collect. Call Close to stop the background
+// goroutine.
+func NewExporter(api API, cfg HistogramConfig) *Exporter {
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = 15 * time.Second
+	}
+
+	ex := &Exporter{
+		API:     api,
+		histCfg: cfg,
+
+		callDurationHist: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:                           prefix + "call_duration_seconds",
+			Help:                           "Duration of finished calls in seconds",
+			Buckets:                        cfg.CallDurationBuckets,
+			NativeHistogramBucketFactor:    cfg.NativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: cfg.NativeHistogramMaxBucketNumber,
+		}),
+		serviceCPUHist: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                           prefix + "service_cpu_sampled",
+			Help:                           "Distribution of sampled CPU usage of service",
+			Buckets:                        cfg.ServiceResourceBuckets,
+			NativeHistogramBucketFactor:    cfg.NativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: cfg.NativeHistogramMaxBucketNumber,
+		}, []string{"name"}),
+		serviceMemoryHist: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                           prefix + "service_memory_sampled",
+			Help:                           "Distribution of sampled memory usage of service",
+			Buckets:                        cfg.ServiceResourceBuckets,
+			NativeHistogramBucketFactor:    cfg.NativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: cfg.NativeHistogramMaxBucketNumber,
+		}, []string{"name"}),
+		trunkRegLatencyHist: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                           prefix + "trunk_registration_latency_seconds",
+			Help:                           "Time taken for a trunk to re-register after going unregistered",
+			Buckets:                        cfg.TrunkRegistrationBuckets,
+			NativeHistogramBucketFactor:    cfg.NativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: cfg.NativeHistogramMaxBucketNumber,
+		}, []string{"name"}),
+
+		trunkLastUnregisteredAt: make(map[string]time.Time),
+		stopSampling:            make(chan struct{}),
+	}
+
+	go ex.sampleLoop()
+	return ex
+}
+
+// Close stops the background sampling goroutine started by NewExporter.
+// Exporters constructed via a bare struct literal have nothing to stop and
+// Close is a no-op for them. Close is safe to call more than once and from
+// multiple goroutines.
+func (ex *Exporter) Close() {
+	if ex.stopSampling == nil {
+		return
+	}
+	ex.closeSamplingOnce.Do(func() {
+		close(ex.stopSampling)
+	})
+}
+
+func (ex *Exporter) sampleLoop() {
+	ticker := time.NewTicker(ex.histCfg.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ex.sampleOnce()
+		case <-ex.stopSampling:
+			return
+		}
+	}
+}
+
+func (ex *Exporter) sampleOnce() {
+	services, err := ex.API.ServiceList()
+	if err != nil {
+		log.Println("failed to sample ServiceList for histograms:", err)
+	} else {
+		calls, err := ex.API.CallHistory()
+		if err != nil {
+			log.Println("failed to fetch CallHistory for exemplars:", err)
+		}
+		lookup := lastCallByService(calls)
+
+		for i := range services {
+			service := services[i]
+			call, hasCall := lookup(service.Name)
+
+			observeWithOptionalExemplar(ex.serviceCPUHist.WithLabelValues(service.Name), float64(service.CPUUsage), call, hasCall)
+			observeWithOptionalExemplar(ex.serviceMemoryHist.WithLabelValues(service.Name), float64(service.MemoryUsed), call, hasCall)
+		}
+	}
+
+	trunks, err := ex.API.TrunkList()
+	if err != nil {
+		log.Println("failed to sample TrunkList for histograms:", err)
+		return
+	}
+
+	ex.trunkStateMu.Lock()
+	defer ex.trunkStateMu.Unlock()
+	for i := range trunks {
+		trunk := trunks[i]
+		if !trunk.IsRegistered {
+			ex.trunkLastUnregisteredAt[trunk.Name] = time.Now()
+			continue
+		}
+		if since, ok := ex.trunkLastUnregisteredAt[trunk.Name]; ok {
+			ex.trunkRegLatencyHist.WithLabelValues(trunk.Name).Observe(time.Since(since).Seconds())
+			delete(ex.trunkLastUnregisteredAt, trunk.Name)
+		}
+	}
+}
+
+// observeWithOptionalExemplar records value into obs, attaching call as an
+// exemplar when hasCall is true and obs supports exemplars. value is the
+// actually sampled measurement (e.g. CPU or memory usage), so the exemplar
+// lands in the bucket the sample itself fell into rather than an unrelated
+// one.
+func observeWithOptionalExemplar(obs prometheus.Observer, value float64, call CallRecord, hasCall bool) {
+	if hasCall {
+		if exemplarObs, ok := obs.(prometheus.ExemplarObserver); ok {
+			exemplarObs.ObserveWithExemplar(value, call.exemplarLabels())
+			return
+		}
+	}
+	obs.Observe(value)
+}
+
+// describeHistograms is called from Exporter.Describe.
+func (ex *Exporter) describeHistograms(ch chan<- *prometheus.Desc) {
+	if ex.callDurationHist == nil {
+		return
+	}
+	ex.callDurationHist.Describe(ch)
+	ex.serviceCPUHist.Describe(ch)
+	ex.serviceMemoryHist.Describe(ch)
+	ex.trunkRegLatencyHist.Describe(ch)
+}
+
+// collectHistograms is called from Exporter.Collect. calls is the call
+// history fetched for this scrape, used to feed the call duration
+// histogram. Service resource exemplars are attached earlier, at sample
+// time in sampleOnce, so serviceCPUHist/serviceMemoryHist are collected
+// directly here.
+func (ex *Exporter) collectHistograms(ch chan<- prometheus.Metric, calls []CallRecord) {
+	if ex.callDurationHist == nil {
+		return
+	}
+
+	ex.observeNewCalls(calls)
+	ex.callDurationHist.Collect(ch)
+	ex.serviceCPUHist.Collect(ch)
+	ex.serviceMemoryHist.Collect(ch)
+	ex.trunkRegLatencyHist.Collect(ch)
+}
+
+// observeNewCalls records the calls in calls that weren't already observed
+// by a previous scrape into the call duration histogram, attaching an
+// exemplar to each. calls must be ordered most recent first, as returned
+// by API.CallHistory.
+func (ex *Exporter) observeNewCalls(calls []CallRecord) {
+	if len(calls) == 0 {
+		return
+	}
+
+	ex.lastObservedCallMu.Lock()
+	defer ex.lastObservedCallMu.Unlock()
+
+	newCalls := calls
+	if ex.lastObservedCallSet {
+		for i, call := range calls {
+			if call.CallID == ex.lastObservedCallID {
+				newCalls = calls[:i]
+				break
+			}
+		}
+	}
+	ex.lastObservedCallID = calls[0].CallID
+	ex.lastObservedCallSet = true
+
+	for _, call := range newCalls {
+		if obs, ok := ex.callDurationHist.(prometheus.ExemplarObserver); ok {
+			obs.ObserveWithExemplar(call.Duration.Seconds(), call.exemplarLabels())
+		} else {
+			ex.callDurationHist.Observe(call.Duration.Seconds())
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// PushConfig configures delivery to a Pushgateway, for 3CX deployments
+// with no inbound network access for Prometheus to scrape (e.g. behind
+// NAT), which must push their own metrics instead of serving /metrics.
+//
+// This package has no cmd/main entry point, so PushConfig/RunPush are
+// library primitives only: wiring --push-url/--push-interval/--push-job
+// flags to a PushConfig and choosing between serving /metrics and calling
+// RunPushExporter is left to whatever command builds on this package.
+type PushConfig struct {
+	// URL is the Pushgateway base URL, e.g. "http://pushgateway:9091".
+	URL string
+
+	// Job is the job label reported to the Pushgateway.
+	Job string
+
+	// Interval controls how often metrics are pushed. Defaults to 15s
+	// when zero.
+	Interval time.Duration
+}
+
+// RunPush pushes the metrics registered on reg to the Pushgateway
+// described by cfg on a ticker, until ctx is done. Push errors are logged
+// rather than returned, since one failed push shouldn't stop a
+// long-running pusher from retrying on the next tick.
+func RunPush(ctx context.Context, reg *prometheus.Registry, cfg PushConfig) error {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	pusher := push.New(cfg.URL, cfg.Job).Gatherer(reg)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := pusher.PushContext(ctx); err != nil {
+				log.Println("failed to push metrics:", err)
+			}
+			if err := pusher.Error(); err != nil {
+				log.Println("last push error:", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RunPushExporter registers ex on a dedicated registry and pushes its
+// metrics to the Pushgateway described by cfg until ctx is done. It's the
+// push-mode counterpart to serving ex via promhttp.Handler.
+func RunPushExporter(ctx context.Context, ex *Exporter, cfg PushConfig) error {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(ex)
+	return RunPush(ctx, reg, cfg)
+}
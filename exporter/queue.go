@@ -0,0 +1,34 @@
+package exporter
+
+import "time"
+
+// DefaultQueueWaitTimeBuckets are the suggested histogram bucket
+// boundaries, in seconds, for QueueWaitTime. API implementations are free
+// to use any boundaries, since the exporter treats them as opaque.
+var DefaultQueueWaitTimeBuckets = []float64{5, 10, 15, 30, 60, 120, 300, 600}
+
+// QueueWaitTime is a pre-aggregated histogram of how long answered calls
+// waited in a queue, as reported by 3CX. It's exposed as a const
+// histogram rather than sampled locally, since 3CX already aggregates it
+// server-side.
+type QueueWaitTime struct {
+	Buckets map[float64]uint64 // cumulative counts, keyed by upper bound
+	Sum     float64
+	Count   uint64
+}
+
+// Queue describes one call-center queue.
+type Queue struct {
+	Name          string
+	CallsWaiting  int
+	CallsAnswered int
+	WaitTime      QueueWaitTime
+}
+
+// Agent describes one call-center agent's standing in a queue.
+type Agent struct {
+	Queue     string
+	Extension string
+	Status    int // 0=logged out, 1=available, 2=busy, 3=wrap-up
+	TalkTime  time.Duration
+}
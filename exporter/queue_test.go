@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeAPI is a minimal API implementation for tests. Each endpoint returns
+// fixed data; callers that only care about a subset of endpoints can
+// still rely on the others not erroring.
+type fakeAPI struct {
+	queues []Queue
+	agents []Agent
+}
+
+func (f fakeAPI) SystemStatus() (*SystemStatus, error) { return &SystemStatus{}, nil }
+func (f fakeAPI) ServiceList() ([]Service, error)      { return nil, nil }
+func (f fakeAPI) TrunkList() ([]Trunk, error)          { return nil, nil }
+func (f fakeAPI) CallHistory() ([]CallRecord, error)   { return nil, nil }
+func (f fakeAPI) QueueList() ([]Queue, error)          { return f.queues, nil }
+func (f fakeAPI) AgentList() ([]Agent, error)          { return f.agents, nil }
+
+func TestExporterCollectQueueAndAgentMetrics(t *testing.T) {
+	fake := fakeAPI{
+		queues: []Queue{
+			{
+				Name:          "Support",
+				CallsWaiting:  3,
+				CallsAnswered: 42,
+				WaitTime: QueueWaitTime{
+					Buckets: map[float64]uint64{10: 5, 30: 8, 60: 9},
+					Sum:     123.4,
+					Count:   9,
+				},
+			},
+		},
+		agents: []Agent{
+			{Queue: "Support", Extension: "101", Status: 1, TalkTime: 90 * time.Second},
+		},
+	}
+
+	ex := &Exporter{API: fake}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(ex); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	families := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+
+	waiting, ok := families["pbx_queue_calls_waiting"]
+	if !ok {
+		t.Fatal("pbx_queue_calls_waiting not collected")
+	}
+	m := findMetricWithLabel(t, waiting, "queue", "Support")
+	if got := m.GetGauge().GetValue(); got != 3 {
+		t.Errorf("pbx_queue_calls_waiting{queue=\"Support\"} = %v, want 3", got)
+	}
+
+	answered, ok := families["pbx_queue_calls_answered_total"]
+	if !ok {
+		t.Fatal("pbx_queue_calls_answered_total not collected")
+	}
+	m = findMetricWithLabel(t, answered, "queue", "Support")
+	if got := m.GetCounter().GetValue(); got != 42 {
+		t.Errorf("pbx_queue_calls_answered_total{queue=\"Support\"} = %v, want 42", got)
+	}
+
+	waitTime, ok := families["pbx_queue_wait_time_seconds"]
+	if !ok {
+		t.Fatal("pbx_queue_wait_time_seconds not collected")
+	}
+	m = findMetricWithLabel(t, waitTime, "queue", "Support")
+	if got := m.GetHistogram().GetSampleCount(); got != 9 {
+		t.Errorf("pbx_queue_wait_time_seconds{queue=\"Support\"} sample count = %v, want 9", got)
+	}
+	if got := m.GetHistogram().GetSampleSum(); got != 123.4 {
+		t.Errorf("pbx_queue_wait_time_seconds{queue=\"Support\"} sample sum = %v, want 123.4", got)
+	}
+
+	status, ok := families["pbx_agent_status"]
+	if !ok {
+		t.Fatal("pbx_agent_status not collected")
+	}
+	m = findMetricWithLabels(t, status, map[string]string{"queue": "Support", "agent": "101"})
+	if got := m.GetGauge().GetValue(); got != 1 {
+		t.Errorf("pbx_agent_status{queue=\"Support\",agent=\"101\"} = %v, want 1", got)
+	}
+
+	talk, ok := families["pbx_agent_talk_time_seconds_total"]
+	if !ok {
+		t.Fatal("pbx_agent_talk_time_seconds_total not collected")
+	}
+	m = findMetricWithLabels(t, talk, map[string]string{"queue": "Support", "agent": "101"})
+	if got := m.GetCounter().GetValue(); got != 90 {
+		t.Errorf("pbx_agent_talk_time_seconds_total{queue=\"Support\",agent=\"101\"} = %v, want 90", got)
+	}
+}
+
+func findMetricWithLabel(t *testing.T, mf *dto.MetricFamily, name, value string) *dto.Metric {
+	t.Helper()
+	return findMetricWithLabels(t, mf, map[string]string{name: value})
+}
+
+func findMetricWithLabels(t *testing.T, mf *dto.MetricFamily, want map[string]string) *dto.Metric {
+	t.Helper()
+	for _, m := range mf.GetMetric() {
+		got := make(map[string]string, len(m.GetLabel()))
+		for _, l := range m.GetLabel() {
+			got[l.GetName()] = l.GetValue()
+		}
+		matches := true
+		for k, v := range want {
+			if got[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return m
+		}
+	}
+	t.Fatalf("%s: no metric with labels %v", mf.GetName(), want)
+	return nil
+}